@@ -0,0 +1,18 @@
+package dns
+
+import (
+	"context"
+
+	D "github.com/miekg/dns"
+)
+
+// dnsClient is the common interface every nameserver transport (plain
+// UDP/TCP/TLS, DoH, DoQ, DHCP-discovered) implements.
+type dnsClient interface {
+	Address() string
+	ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error)
+
+	// ECSPolicy reports this nameserver's EDNS Client Subnet policy, so
+	// batchExchange knows whether to attach an ECS option for it.
+	ECSPolicy() ECSPolicy
+}
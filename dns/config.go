@@ -0,0 +1,35 @@
+package dns
+
+// NameServer is a single configured upstream, parsed from the `nameserver`
+// (or a `nameserver-policy` group) section of the DNS config.
+type NameServer struct {
+	Net       string
+	Addr      string
+	Proxy     string
+	Interface string
+	IsDHCP    bool
+
+	// ECSPolicy is the raw `ecs-policy` value for this nameserver:
+	// "disabled", "auto", or "override <cidr>". See ParseECSPolicy.
+	ECSPolicy string
+}
+
+// Config is the DNS section of the root config file, used to build a
+// Resolver via NewResolver.
+type Config struct {
+	Main     []NameServer
+	Fallback []NameServer
+
+	// MaxNegativeTTL caps how long an NXDOMAIN/NODATA answer is cached
+	// (RFC 2308 negative caching), in seconds. 0 uses defaultMaxNegativeTTL.
+	MaxNegativeTTL uint32
+
+	// Rules are the per-domain nameserver-group rules (DOMAIN-SUFFIX,
+	// GEOSITE, ...), matched in order before falling back to Main/Fallback.
+	Rules []Rule
+	// RuleGroups maps a Rule's Group to the nameservers used for it.
+	RuleGroups map[string][]NameServer
+	// RuleProxies optionally maps a Rule's Group to the outbound proxy its
+	// DoH/DoT clients should dial through.
+	RuleProxies map[string]string
+}
@@ -0,0 +1,249 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+
+	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+
+	"github.com/Dreamacro/clash/component/resolver"
+)
+
+// doqALPN is the ALPN token registered for DNS-over-QUIC in RFC 9250.
+const doqALPN = "doq"
+
+// doqClient implements dnsClient for RFC 9250 DNS-over-QUIC: a single QUIC
+// connection to the resolver is reused across queries, with each query sent
+// on its own bidirectional stream using the same 2-byte length prefix as
+// DNS-over-TCP.
+type doqClient struct {
+	addr         string
+	proxyAdapter string
+	iface        string
+	r            *Resolver
+
+	mu           sync.Mutex
+	conn         quic.Connection
+	sessionCache tls.ClientSessionCache
+	ecsPolicy    ECSPolicy
+}
+
+func newDoQClient(addr, proxyAdapter, iface string, r *Resolver, ecsPolicy ECSPolicy) *doqClient {
+	return &doqClient{
+		addr:         addr,
+		proxyAdapter: proxyAdapter,
+		iface:        iface,
+		r:            r,
+		ecsPolicy:    ecsPolicy,
+		// Shared across dial/reconnect so a reconnect after the connection
+		// goes idle can resume the previous session and complete in 0-RTT.
+		sessionCache: tls.NewLRUClientSessionCache(1),
+	}
+}
+
+func (dc *doqClient) Address() string {
+	return "quic://" + dc.addr
+}
+
+func (dc *doqClient) ECSPolicy() ECSPolicy {
+	return dc.ecsPolicy
+}
+
+func (dc *doqClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	conn, err := dc.getConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		// The connection may have gone idle and been torn down by the peer;
+		// reconnect once with 0-RTT before giving up.
+		conn, err = dc.reconnect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if stream, err = conn.OpenStreamSync(ctx); err != nil {
+			return nil, err
+		}
+	}
+	defer stream.Close()
+
+	req := m.Copy()
+	req.Id = 0 // RFC 9250 section 4.2.1: the DNS message ID must be 0 on the wire.
+
+	raw, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 2+len(raw))
+	binary.BigEndian.PutUint16(buf, uint16(len(raw)))
+	copy(buf[2:], raw)
+
+	return dc.exchangeOnStream(ctx, stream, buf, m.Id)
+}
+
+// exchangeOnStream writes buf to stream and reads back the length-prefixed
+// reply, racing the I/O against ctx.Done(). quic-go streams don't watch a
+// parent context past OpenStreamSync, so without this a hedged-out query
+// (or any other cancellation) would block until the QUIC connection's own
+// idle timeout or a peer reset instead of returning promptly, leaking the
+// goroutine and the open stream.
+func (dc *doqClient) exchangeOnStream(ctx context.Context, stream quic.Stream, buf []byte, id uint16) (*D.Msg, error) {
+	type result struct {
+		msg *D.Msg
+		err error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		if _, err := stream.Write(buf); err != nil {
+			done <- result{err: err}
+			return
+		}
+		_ = stream.Close()
+
+		var length uint16
+		if err := binary.Read(stream, binary.BigEndian, &length); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		resp := make([]byte, length)
+		if _, err := io.ReadFull(stream, resp); err != nil {
+			done <- result{err: fmt.Errorf("doq: short read: %w", err)}
+			return
+		}
+
+		reply := &D.Msg{}
+		if err := reply.Unpack(resp); err != nil {
+			done <- result{err: err}
+			return
+		}
+		reply.Id = id
+		done <- result{msg: reply}
+	}()
+
+	select {
+	case r := <-done:
+		return r.msg, r.err
+	case <-ctx.Done():
+		stream.CancelWrite(0)
+		stream.CancelRead(0)
+		return nil, ctx.Err()
+	}
+}
+
+func (dc *doqClient) getConnection(ctx context.Context) (quic.Connection, error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if dc.conn != nil {
+		select {
+		case <-dc.conn.Context().Done():
+			// Previous connection died; fall through and dial a fresh one.
+		default:
+			return dc.conn, nil
+		}
+	}
+
+	conn, err := dc.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dc.conn = conn
+	return conn, nil
+}
+
+func (dc *doqClient) reconnect(ctx context.Context) (quic.Connection, error) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	conn, err := dc.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dc.conn = conn
+	return conn, nil
+}
+
+func (dc *doqClient) dial(ctx context.Context) (quic.Connection, error) {
+	host, port, err := net.SplitHostPort(dc.addr)
+	if err != nil {
+		host = dc.addr
+		port = "853"
+	}
+
+	ip, err := resolver.ResolveIPWithResolver(ctx, host, dc.r)
+	if err != nil {
+		return nil, fmt.Errorf("doq: resolve %s: %w", host, err)
+	}
+
+	// Resolved once here and reused for both the outer UDP socket and the
+	// QUIC peer address below, so quic-go's off-path checks see the same
+	// address the packets actually go to instead of two independent
+	// resolutions of a hostname that may round-robin between calls.
+	pc, err := dc.listenPacket(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(ip.String(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConf := &tls.Config{
+		ServerName:         host,
+		NextProtos:         []string{doqALPN},
+		ClientSessionCache: dc.sessionCache,
+	}
+	return quic.DialEarly(ctx, pc, udpAddr, tlsConf, nil)
+}
+
+// listenPacket opens the underlying UDP flow DoQ is transported over,
+// honouring the nameserver's configured proxy or outbound interface. ip is
+// the already-resolved destination address, shared with the caller so the
+// host is only looked up once per dial.
+func (dc *doqClient) listenPacket(ctx context.Context, ip netip.Addr) (net.PacketConn, error) {
+	proxyOrInterface := dc.proxyAdapter
+	if proxyOrInterface == "" {
+		proxyOrInterface = dc.iface
+	}
+
+	_, port, err := net.SplitHostPort(dc.addr)
+	if err != nil {
+		port = "853"
+	}
+
+	conn, err := dialContextByProxyOrInterface(ctx, "udp", ip, port, proxyOrInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connPacketConn{Conn: conn}, nil
+}
+
+// connPacketConn adapts the net.Conn returned by dialContextByProxyOrInterface
+// back into a net.PacketConn, mirroring wrapPacketConn's conversion the
+// other way around.
+type connPacketConn struct {
+	net.Conn
+}
+
+func (cpc *connPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := cpc.Conn.Read(b)
+	return n, cpc.Conn.RemoteAddr(), err
+}
+
+func (cpc *connPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return cpc.Conn.Write(b)
+}
@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	D "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// fakeQUICStream is a minimal quic.Stream double for exercising
+// exchangeOnStream's framing and cancellation behaviour without a real QUIC
+// handshake. It embeds the interface so unexercised methods (StreamID,
+// SetDeadline, ...) are satisfied for free; only the methods
+// exchangeOnStream actually calls are overridden.
+type fakeQUICStream struct {
+	quic.Stream
+
+	reader io.Reader
+	closer io.Closer // closed by CancelRead/CancelWrite to unblock a pending Read
+
+	mu          sync.Mutex
+	written     []byte
+	closeCalled bool
+	cancelWrite bool
+	cancelRead  bool
+}
+
+func (s *fakeQUICStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, p...)
+	return len(p), nil
+}
+
+func (s *fakeQUICStream) Close() error {
+	s.mu.Lock()
+	s.closeCalled = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fakeQUICStream) Read(p []byte) (int, error) {
+	return s.reader.Read(p)
+}
+
+func (s *fakeQUICStream) CancelWrite(quic.StreamErrorCode) {
+	s.mu.Lock()
+	s.cancelWrite = true
+	s.mu.Unlock()
+	if s.closer != nil {
+		_ = s.closer.Close()
+	}
+}
+
+func (s *fakeQUICStream) CancelRead(quic.StreamErrorCode) {
+	s.mu.Lock()
+	s.cancelRead = true
+	s.mu.Unlock()
+	if s.closer != nil {
+		_ = s.closer.Close()
+	}
+}
+
+func (s *fakeQUICStream) snapshot() (closeCalled, cancelWrite, cancelRead bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeCalled, s.cancelWrite, s.cancelRead
+}
+
+// packReply builds the length-prefixed wire form exchangeOnStream expects
+// to read back, mirroring the framing ExchangeContext writes on the way out.
+func packReply(t *testing.T, msg *D.Msg) []byte {
+	t.Helper()
+	raw, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("msg.Pack() error = %v", err)
+	}
+	buf := make([]byte, 2+len(raw))
+	binary.BigEndian.PutUint16(buf, uint16(len(raw)))
+	copy(buf[2:], raw)
+	return buf
+}
+
+func TestExchangeOnStreamRoundTrip(t *testing.T) {
+	reply := &D.Msg{}
+	reply.SetQuestion("example.com.", D.TypeA)
+	reply.Id = 0 // RFC 9250 section 4.2.1: zeroed on the wire like the request.
+
+	stream := &fakeQUICStream{reader: bytes.NewReader(packReply(t, reply))}
+
+	req := []byte("fake-packed-request")
+	dc := &doqClient{}
+	got, err := dc.exchangeOnStream(context.Background(), stream, req, 42)
+	if err != nil {
+		t.Fatalf("exchangeOnStream() error = %v", err)
+	}
+	if got.Id != 42 {
+		t.Fatalf("reply.Id = %d, want 42 (restored from the original request id)", got.Id)
+	}
+	if len(got.Question) != 1 || got.Question[0].Name != "example.com." {
+		t.Fatalf("reply unpacked to %+v, want the question round-tripped intact", got)
+	}
+
+	closeCalled, _, _ := stream.snapshot()
+	if !bytes.Equal(stream.written, req) {
+		t.Fatalf("stream.Write got %v, want %v", stream.written, req)
+	}
+	if !closeCalled {
+		t.Fatal("exchangeOnStream did not half-close the stream's send side after writing")
+	}
+}
+
+func TestExchangeOnStreamShortReadIsAnError(t *testing.T) {
+	// The length prefix claims more bytes than are actually on the wire.
+	buf := []byte{0x00, 0x10, 0x01, 0x02}
+	stream := &fakeQUICStream{reader: bytes.NewReader(buf)}
+
+	dc := &doqClient{}
+	if _, err := dc.exchangeOnStream(context.Background(), stream, []byte("req"), 1); err == nil {
+		t.Fatal("exchangeOnStream() with a truncated reply succeeded, want a short-read error")
+	}
+}
+
+func TestExchangeOnStreamCancelsOnContextDone(t *testing.T) {
+	// Regression test: a hedge loser (or any other ctx cancellation) must
+	// unblock a pending read instead of hanging on the stream until the
+	// QUIC connection's idle timeout.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	stream := &fakeQUICStream{reader: pr, closer: pr}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	type outcome struct {
+		msg *D.Msg
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		dc := &doqClient{}
+		msg, err := dc.exchangeOnStream(ctx, stream, []byte("req"), 7)
+		done <- outcome{msg, err}
+	}()
+
+	cancel()
+
+	select {
+	case o := <-done:
+		if !errors.Is(o.err, context.Canceled) {
+			t.Fatalf("exchangeOnStream() error = %v, want context.Canceled", o.err)
+		}
+		if o.msg != nil {
+			t.Fatalf("exchangeOnStream() = %+v, want nil on cancellation", o.msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("exchangeOnStream did not return promptly after ctx was canceled")
+	}
+
+	_, cancelWrite, cancelRead := stream.snapshot()
+	if !cancelWrite || !cancelRead {
+		t.Fatalf("exchangeOnStream did not cancel the stream on ctx cancellation: cancelWrite=%v cancelRead=%v", cancelWrite, cancelRead)
+	}
+}
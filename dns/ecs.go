@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	D "github.com/miekg/dns"
+)
+
+// ECSMode selects how a nameserver attaches an EDNS Client Subnet option to
+// outgoing queries.
+type ECSMode int
+
+const (
+	// ECSDisabled never attaches an ECS option.
+	ECSDisabled ECSMode = iota
+	// ECSAuto derives a /24 (IPv4) or /56 (IPv6) prefix from the client
+	// address seen by the local DNS listener.
+	ECSAuto
+	// ECSOverride always attaches a fixed, user-configured CIDR.
+	ECSOverride
+)
+
+// ECSPolicy is a per-nameserver EDNS Client Subnet policy, parsed from the
+// NameServer config's `ecs-policy` field (`disabled`, `auto`, or
+// `override <cidr>`).
+type ECSPolicy struct {
+	Mode   ECSMode
+	Prefix netip.Prefix
+}
+
+// ParseECSPolicy parses the `ecs-policy` nameserver config value.
+func ParseECSPolicy(s string) (ECSPolicy, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "" || s == "disabled":
+		return ECSPolicy{Mode: ECSDisabled}, nil
+	case s == "auto":
+		return ECSPolicy{Mode: ECSAuto}, nil
+	case strings.HasPrefix(s, "override "):
+		cidr := strings.TrimSpace(strings.TrimPrefix(s, "override "))
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return ECSPolicy{}, fmt.Errorf("invalid ecs-policy override cidr %q: %w", cidr, err)
+		}
+		// RFC 7871 section 6: ADDRESS must be truncated to SourceNetmask
+		// bits, so a prefix with non-zero host bits can't be used as-is.
+		return ECSPolicy{Mode: ECSOverride, Prefix: prefix.Masked()}, nil
+	default:
+		return ECSPolicy{}, fmt.Errorf("invalid ecs-policy %q, must be disabled, auto, or \"override <cidr>\"", s)
+	}
+}
+
+// clientAddrKey carries the source address of the client that issued the
+// query, as seen by the local DNS listener, so ECSAuto can derive a subnet
+// for it downstream.
+const clientAddrKey = contextKey("key-dns-client-addr")
+
+// WithClientAddr attaches the querying client's source address to ctx, for
+// ECSAuto and per-query cache differentiation.
+func WithClientAddr(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, clientAddrKey, addr)
+}
+
+func clientAddrFromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(clientAddrKey).(netip.Addr)
+	return addr, ok
+}
+
+// ecsSubnet resolves the subnet ECSPolicy should attach for this query:
+// the override CIDR, or the auto-derived /24 (IPv4) / /56 (IPv6) of the
+// client address carried in ctx.
+func ecsSubnet(ctx context.Context, policy ECSPolicy) (netip.Prefix, bool) {
+	switch policy.Mode {
+	case ECSOverride:
+		return policy.Prefix, true
+	case ECSAuto:
+		addr, ok := clientAddrFromContext(ctx)
+		if !ok || !addr.IsValid() {
+			return netip.Prefix{}, false
+		}
+		bits := 24
+		if addr.Is6() {
+			bits = 56
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return netip.Prefix{}, false
+		}
+		return prefix, true
+	default:
+		return netip.Prefix{}, false
+	}
+}
+
+// withECS returns a copy of m with an ECS OPT record attached per policy,
+// or m unchanged if policy is disabled or no subnet can be derived.
+func withECS(ctx context.Context, m *D.Msg, policy ECSPolicy) *D.Msg {
+	if policy.Mode == ECSDisabled {
+		return m
+	}
+
+	prefix, ok := ecsSubnet(ctx, policy)
+	if !ok {
+		return m
+	}
+
+	family := uint16(1)
+	addr := prefix.Addr()
+	ip := addr.AsSlice()
+	if addr.Is6() {
+		family = 2
+	}
+
+	subnet := &D.EDNS0_SUBNET{
+		Code:          D.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: uint8(prefix.Bits()),
+		SourceScope:   0,
+		Address:       ip,
+	}
+
+	mm := m.Copy()
+	if opt := mm.IsEdns0(); opt != nil {
+		opt.Option = append(opt.Option, subnet)
+		return mm
+	}
+
+	mm.SetEdns0(4096, false)
+	opt := mm.IsEdns0()
+	opt.Option = append(opt.Option, subnet)
+	return mm
+}
+
+// groupHasECSAuto reports whether any client in clients is configured with
+// ecs-policy: auto, i.e. whether a query routed to this group can actually
+// vary its upstream answer by client subnet.
+func groupHasECSAuto(clients []dnsClient) bool {
+	for _, c := range clients {
+		if c.ECSPolicy().Mode == ECSAuto {
+			return true
+		}
+	}
+	return false
+}
+
+// ecsCacheKey returns the client's auto-derived subnet (/24 IPv4, /56 IPv6),
+// so two clients in different subnets don't share a cached answer whose ECS
+// scope differs. It returns "" unless hasECSAuto is set, so deployments
+// where no nameserver in the resolved group uses ecs-policy: auto keep one
+// shared cache entry per query instead of fragmenting it by client subnet
+// for no reason. Override policies use a fixed upstream subnet for every
+// client, so they need no cache differentiation either.
+func ecsCacheKey(ctx context.Context, hasECSAuto bool) string {
+	if !hasECSAuto {
+		return ""
+	}
+	prefix, ok := ecsSubnet(ctx, ECSPolicy{Mode: ECSAuto})
+	if !ok {
+		return ""
+	}
+	return prefix.String()
+}
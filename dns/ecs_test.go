@@ -0,0 +1,135 @@
+package dns
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+)
+
+func TestParseECSPolicy(t *testing.T) {
+	t.Run("empty and disabled", func(t *testing.T) {
+		for _, s := range []string{"", "disabled"} {
+			policy, err := ParseECSPolicy(s)
+			if err != nil {
+				t.Fatalf("ParseECSPolicy(%q) returned error: %v", s, err)
+			}
+			if policy.Mode != ECSDisabled {
+				t.Fatalf("ParseECSPolicy(%q) = %+v, want ECSDisabled", s, policy)
+			}
+		}
+	})
+
+	t.Run("auto", func(t *testing.T) {
+		policy, err := ParseECSPolicy("auto")
+		if err != nil {
+			t.Fatalf("ParseECSPolicy(auto) returned error: %v", err)
+		}
+		if policy.Mode != ECSAuto {
+			t.Fatalf("ParseECSPolicy(auto) = %+v, want ECSAuto", policy)
+		}
+	})
+
+	t.Run("override masks host bits per RFC 7871 section 6", func(t *testing.T) {
+		policy, err := ParseECSPolicy("override 203.0.113.42/24")
+		if err != nil {
+			t.Fatalf("ParseECSPolicy(override) returned error: %v", err)
+		}
+		if policy.Mode != ECSOverride {
+			t.Fatalf("ParseECSPolicy(override) mode = %v, want ECSOverride", policy.Mode)
+		}
+		want := netip.MustParsePrefix("203.0.113.0/24")
+		if policy.Prefix != want {
+			t.Fatalf("ParseECSPolicy(override) prefix = %v, want %v (host bits masked)", policy.Prefix, want)
+		}
+	})
+
+	t.Run("override rejects invalid cidr", func(t *testing.T) {
+		if _, err := ParseECSPolicy("override not-a-cidr"); err == nil {
+			t.Fatal("ParseECSPolicy(override not-a-cidr) succeeded, want error")
+		}
+	})
+
+	t.Run("unknown value", func(t *testing.T) {
+		if _, err := ParseECSPolicy("bogus"); err == nil {
+			t.Fatal("ParseECSPolicy(bogus) succeeded, want error")
+		}
+	})
+}
+
+func TestEcsSubnetAuto(t *testing.T) {
+	t.Run("missing client address", func(t *testing.T) {
+		if _, ok := ecsSubnet(context.Background(), ECSPolicy{Mode: ECSAuto}); ok {
+			t.Fatal("ecsSubnet(ECSAuto) succeeded without a client address in ctx")
+		}
+	})
+
+	t.Run("derives /24 for IPv4", func(t *testing.T) {
+		ctx := WithClientAddr(context.Background(), netip.MustParseAddr("198.51.100.7"))
+		prefix, ok := ecsSubnet(ctx, ECSPolicy{Mode: ECSAuto})
+		if !ok {
+			t.Fatal("ecsSubnet(ECSAuto) failed with a valid client address")
+		}
+		if want := netip.MustParsePrefix("198.51.100.0/24"); prefix != want {
+			t.Fatalf("ecsSubnet(ECSAuto) = %v, want %v", prefix, want)
+		}
+	})
+
+	t.Run("derives /56 for IPv6", func(t *testing.T) {
+		ctx := WithClientAddr(context.Background(), netip.MustParseAddr("2001:db8:1:2:3::1"))
+		prefix, ok := ecsSubnet(ctx, ECSPolicy{Mode: ECSAuto})
+		if !ok {
+			t.Fatal("ecsSubnet(ECSAuto) failed with a valid client address")
+		}
+		if prefix.Bits() != 56 {
+			t.Fatalf("ecsSubnet(ECSAuto) bits = %d, want 56", prefix.Bits())
+		}
+	})
+}
+
+func TestEcsCacheKeyDiffersPerSubnet(t *testing.T) {
+	ctxA := WithClientAddr(context.Background(), netip.MustParseAddr("198.51.100.7"))
+	ctxB := WithClientAddr(context.Background(), netip.MustParseAddr("198.51.101.9"))
+
+	keyA := ecsCacheKey(ctxA, true)
+	keyB := ecsCacheKey(ctxB, true)
+
+	if keyA == "" || keyB == "" {
+		t.Fatal("ecsCacheKey returned empty key for a valid client address")
+	}
+	if keyA == keyB {
+		t.Fatalf("ecsCacheKey collided for clients in different /24 subnets: %q", keyA)
+	}
+	if got := ecsCacheKey(context.Background(), true); got != "" {
+		t.Fatalf("ecsCacheKey(no client addr) = %q, want empty", got)
+	}
+}
+
+func TestEcsCacheKeyIgnoredWithoutECSAuto(t *testing.T) {
+	// Regression test: a group with no ECSAuto nameserver must not
+	// fragment the shared cache by client subnet, even though a client
+	// address is attached to ctx for essentially every real query.
+	ctxA := WithClientAddr(context.Background(), netip.MustParseAddr("198.51.100.7"))
+	ctxB := WithClientAddr(context.Background(), netip.MustParseAddr("198.51.101.9"))
+
+	if got := ecsCacheKey(ctxA, false); got != "" {
+		t.Fatalf("ecsCacheKey(hasECSAuto=false) = %q, want empty", got)
+	}
+	if got := ecsCacheKey(ctxB, false); got != "" {
+		t.Fatalf("ecsCacheKey(hasECSAuto=false) = %q, want empty", got)
+	}
+}
+
+func TestGroupHasECSAuto(t *testing.T) {
+	autoClient := &doqClient{ecsPolicy: ECSPolicy{Mode: ECSAuto}}
+	disabledClient := &doqClient{ecsPolicy: ECSPolicy{Mode: ECSDisabled}}
+
+	if groupHasECSAuto(nil) {
+		t.Fatal("groupHasECSAuto(nil) = true, want false")
+	}
+	if groupHasECSAuto([]dnsClient{disabledClient}) {
+		t.Fatal("groupHasECSAuto([disabled]) = true, want false")
+	}
+	if !groupHasECSAuto([]dnsClient{disabledClient, autoClient}) {
+		t.Fatal("groupHasECSAuto([disabled, auto]) = false, want true")
+	}
+}
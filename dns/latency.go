@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+const (
+	// minHedgeDelay/maxHedgeDelay bound the wait before fanning out to the
+	// next client, per "The Tail at Scale"'s hedged-request pattern.
+	minHedgeDelay = 50 * time.Millisecond
+	maxHedgeDelay = 300 * time.Millisecond
+
+	rttSampleSize = 20
+)
+
+// ClientStat is a point-in-time snapshot of a dnsClient's recent
+// performance, exported for observability via the RESTful API.
+type ClientStat struct {
+	Address     string        `json:"address"`
+	EWMA        time.Duration `json:"ewma"`
+	P95         time.Duration `json:"p95"`
+	SuccessRate float64       `json:"successRate"`
+	Samples     int           `json:"samples"`
+}
+
+// rttStats tracks a single dnsClient's recent successful RTT (as an EWMA and
+// a sliding window used to derive p95) and success rate; failed exchanges
+// only count toward the success rate, so a client that fails fast can't
+// game its way to the front of orderClientsByLatency.
+type rttStats struct {
+	mu      sync.Mutex
+	ewma    time.Duration
+	samples []time.Duration
+	success int
+	total   int
+}
+
+func (s *rttStats) record(d time.Duration, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total++
+	if !ok {
+		return
+	}
+	s.success++
+
+	if s.ewma == 0 {
+		s.ewma = d
+	} else {
+		s.ewma = s.ewma/2 + d/2
+	}
+
+	s.samples = append(s.samples, d)
+	if len(s.samples) > rttSampleSize {
+		s.samples = s.samples[len(s.samples)-rttSampleSize:]
+	}
+}
+
+func (s *rttStats) snapshot() (ewma, p95 time.Duration, successRate float64, samples int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ewma = s.ewma
+	samples = len(s.samples)
+	if samples > 0 {
+		sorted := append([]time.Duration(nil), s.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		p95 = sorted[int(float64(len(sorted)-1)*0.95)]
+	}
+	if s.total > 0 {
+		successRate = float64(s.success) / float64(s.total)
+	}
+	return
+}
+
+// clientStatsRegistry is the process-wide table of per-client stats, keyed
+// by dnsClient.Address().
+type clientStatsRegistry struct {
+	mu    sync.RWMutex
+	stats map[string]*rttStats
+}
+
+var globalClientStats = &clientStatsRegistry{stats: map[string]*rttStats{}}
+
+func (r *clientStatsRegistry) get(addr string) *rttStats {
+	r.mu.RLock()
+	s, ok := r.stats[addr]
+	r.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok = r.stats[addr]; ok {
+		return s
+	}
+	s = &rttStats{}
+	r.stats[addr] = s
+	return s
+}
+
+func (r *clientStatsRegistry) record(addr string, d time.Duration, ok bool) {
+	r.get(addr).record(d, ok)
+}
+
+// ewma returns the client's EWMA RTT, or a large sentinel for clients with
+// no samples yet so untried clients hedge in after every known-fast client.
+func (r *clientStatsRegistry) ewma(addr string) time.Duration {
+	ewma, _, _, samples := r.get(addr).snapshot()
+	if samples == 0 {
+		return time.Hour
+	}
+	return ewma
+}
+
+// hedgeDelay returns how long batchExchange should wait for addr's turn
+// before moving on to the next client, bounded to [minHedgeDelay, maxHedgeDelay].
+func (r *clientStatsRegistry) hedgeDelay(addr string) time.Duration {
+	_, p95, _, samples := r.get(addr).snapshot()
+	if samples == 0 {
+		return maxHedgeDelay
+	}
+	return lo.Clamp(p95, minHedgeDelay, maxHedgeDelay)
+}
+
+// DNSClientStats returns a snapshot of every tracked dnsClient's recent RTT
+// and success rate, for the RESTful API's observability endpoints.
+func DNSClientStats() []ClientStat {
+	globalClientStats.mu.RLock()
+	defer globalClientStats.mu.RUnlock()
+
+	out := make([]ClientStat, 0, len(globalClientStats.stats))
+	for addr, s := range globalClientStats.stats {
+		ewma, p95, successRate, samples := s.snapshot()
+		out = append(out, ClientStat{
+			Address:     addr,
+			EWMA:        ewma,
+			P95:         p95,
+			SuccessRate: successRate,
+			Samples:     samples,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// orderClientsByLatency returns a copy of clients sorted by known EWMA RTT
+// ascending, so the fastest known client is tried first.
+func orderClientsByLatency(clients []dnsClient) []dnsClient {
+	ordered := append([]dnsClient(nil), clients...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return globalClientStats.ewma(ordered[i].Address()) < globalClientStats.ewma(ordered[j].Address())
+	})
+	return ordered
+}
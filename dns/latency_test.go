@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	D "github.com/miekg/dns"
+)
+
+// stubClient is a minimal dnsClient for exercising the latency-aware
+// scheduler without a real transport.
+type stubClient struct {
+	addr  string
+	delay time.Duration
+}
+
+func (s *stubClient) Address() string { return s.addr }
+
+func (s *stubClient) ExchangeContext(ctx context.Context, m *D.Msg) (*D.Msg, error) {
+	select {
+	case <-time.After(s.delay):
+		return &D.Msg{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *stubClient) ECSPolicy() ECSPolicy { return ECSPolicy{Mode: ECSDisabled} }
+
+func resetClientStats(addrs ...string) {
+	globalClientStats.mu.Lock()
+	for _, addr := range addrs {
+		delete(globalClientStats.stats, addr)
+	}
+	globalClientStats.mu.Unlock()
+}
+
+func TestOrderClientsByLatency(t *testing.T) {
+	fast := &stubClient{addr: "fast"}
+	slow := &stubClient{addr: "slow"}
+	unknown := &stubClient{addr: "unknown"}
+	defer resetClientStats(fast.addr, slow.addr, unknown.addr)
+
+	globalClientStats.record(fast.addr, 10*time.Millisecond, true)
+	globalClientStats.record(slow.addr, 200*time.Millisecond, true)
+
+	ordered := orderClientsByLatency([]dnsClient{slow, unknown, fast})
+	if len(ordered) != 3 || ordered[0].Address() != "fast" || ordered[1].Address() != "slow" || ordered[2].Address() != "unknown" {
+		got := make([]string, len(ordered))
+		for i, c := range ordered {
+			got[i] = c.Address()
+		}
+		t.Fatalf("orderClientsByLatency() order = %v, want [fast slow unknown]", got)
+	}
+}
+
+func TestRecordIgnoresFailedRTTForEWMA(t *testing.T) {
+	// Regression test: a nameserver that fails instantly must not build the
+	// lowest EWMA of the group just from failing fast, or it would keep
+	// winning orderClientsByLatency and get tried first forever.
+	failing := &stubClient{addr: "fails-fast"}
+	working := &stubClient{addr: "works"}
+	defer resetClientStats(failing.addr, working.addr)
+
+	for i := 0; i < 5; i++ {
+		globalClientStats.record(failing.addr, time.Millisecond, false)
+	}
+	globalClientStats.record(working.addr, 50*time.Millisecond, true)
+
+	ewma, _, successRate, samples := globalClientStats.get(failing.addr).snapshot()
+	if samples != 0 || ewma != 0 {
+		t.Fatalf("failing client: samples = %d, ewma = %v, want 0, 0", samples, ewma)
+	}
+	if successRate != 0 {
+		t.Fatalf("failing client: successRate = %v, want 0", successRate)
+	}
+
+	ordered := orderClientsByLatency([]dnsClient{failing, working})
+	if ordered[0].Address() != "works" {
+		t.Fatalf("orderClientsByLatency() put %q first, want the working client tried first", ordered[0].Address())
+	}
+}
+
+func TestHedgeRemainingWaitsOnInFlightClient(t *testing.T) {
+	// Regression test: hedgeRemaining must base its wait on the client
+	// currently in flight, not the next candidate about to launch. An
+	// in-flight client with a tight p95 must cause a prompt hedge to a
+	// never-tried backup, well before the backup's own untried delay
+	// (maxHedgeDelay) would have elapsed.
+	inFlightAddr := "in-flight"
+	defer resetClientStats(inFlightAddr)
+
+	// Give the in-flight client a tight p95 so the hedge fires quickly.
+	for i := 0; i < 5; i++ {
+		globalClientStats.record(inFlightAddr, 5*time.Millisecond, true)
+	}
+
+	start := time.Now()
+	var mu sync.Mutex
+	var launched []string
+	var firstLaunch time.Duration
+	launch := func(c dnsClient) {
+		mu.Lock()
+		if len(launched) == 0 {
+			firstLaunch = time.Since(start)
+		}
+		launched = append(launched, c.Address())
+		mu.Unlock()
+	}
+
+	ordered := []dnsClient{
+		&stubClient{addr: inFlightAddr},
+		&stubClient{addr: "backup-1"},
+		&stubClient{addr: "backup-2"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		hedgeRemaining(ctx, ordered, launch)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("hedgeRemaining did not return after ctx expired")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(launched) == 0 {
+		t.Fatal("hedgeRemaining never launched a backup for a slow in-flight client")
+	}
+	if launched[0] != "backup-1" {
+		t.Fatalf("hedgeRemaining launched %v first, want backup-1", launched[0])
+	}
+	if firstLaunch >= maxHedgeDelay {
+		t.Fatalf("hedgeRemaining waited %v before the first hedge, want less than %v (the in-flight client's delay, not the untried backup's)", firstLaunch, maxHedgeDelay)
+	}
+}
+
+func TestHedgeRemainingStopsOnCancel(t *testing.T) {
+	defer resetClientStats("never-tried")
+
+	launched := 0
+	launch := func(c dnsClient) { launched++ }
+
+	ordered := []dnsClient{
+		&stubClient{addr: "never-tried"},
+		&stubClient{addr: "backup"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	hedgeRemaining(ctx, ordered, launch)
+
+	if launched != 0 {
+		t.Fatalf("hedgeRemaining launched %d backups after ctx was already cancelled, want 0", launched)
+	}
+}
@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// queryLogBufferSize is the depth of the in-memory ring buffer backing the
+// /dns/queries API endpoint.
+const queryLogBufferSize = 200
+
+// QueryLog is a single query/response pair, emitted for every DNS exchange
+// so external UIs can render a live DNS log the same way dashboards render
+// HTTP connections.
+type QueryLog struct {
+	Time     time.Time     `json:"time"`
+	ClientIP string        `json:"clientIP"`
+	// Process is the name of the process that issued the query, attributed
+	// via the same process resolver the Process/ProcessPath rule types use.
+	// That resolver (and the inbound listener's socket metadata it needs)
+	// isn't part of this tree, so this is always "" here; left in place,
+	// rather than dropped, so the field lines up with the rest of the
+	// series and a future patch can wire it up without another API change.
+	Process  string        `json:"process"`
+	Rule     string        `json:"rule"`
+	QName    string        `json:"qname"`
+	QType    string        `json:"qtype"`
+	Upstream string        `json:"upstream"`
+	Proxy    string        `json:"proxy,omitempty"`
+	RTT      time.Duration `json:"rtt"`
+	CacheHit bool          `json:"cacheHit"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// queryLogRing is the process-wide ring buffer and fan-out point for
+// QueryLog entries, read by the /dns/queries API and SubscribeQueries.
+type queryLogRing struct {
+	mu   sync.Mutex
+	buf  []QueryLog
+	subs map[chan QueryLog]struct{}
+}
+
+var globalQueryLog = &queryLogRing{subs: map[chan QueryLog]struct{}{}}
+
+func (r *queryLogRing) push(q QueryLog) {
+	r.mu.Lock()
+	r.buf = append(r.buf, q)
+	if len(r.buf) > queryLogBufferSize {
+		r.buf = r.buf[len(r.buf)-queryLogBufferSize:]
+	}
+	subs := make([]chan QueryLog, 0, len(r.subs))
+	for ch := range r.subs {
+		subs = append(subs, ch)
+	}
+	r.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- q:
+		default:
+			// Slow subscriber; drop rather than block the resolver.
+		}
+	}
+}
+
+// recentQueries returns the queries held in the ring buffer, oldest first.
+func recentQueries() []QueryLog {
+	globalQueryLog.mu.Lock()
+	defer globalQueryLog.mu.Unlock()
+	return append([]QueryLog(nil), globalQueryLog.buf...)
+}
+
+// subscribeQueries streams every query/response pair pushed to the ring
+// buffer. Callers must drain the returned channel promptly; a slow
+// consumer has entries dropped rather than blocking the resolver. Call
+// cancel to unsubscribe.
+func subscribeQueries() (queries <-chan QueryLog, cancel func()) {
+	ch := make(chan QueryLog, 64)
+
+	globalQueryLog.mu.Lock()
+	globalQueryLog.subs[ch] = struct{}{}
+	globalQueryLog.mu.Unlock()
+
+	cancel = func() {
+		globalQueryLog.mu.Lock()
+		delete(globalQueryLog.subs, ch)
+		globalQueryLog.mu.Unlock()
+	}
+
+	return ch, cancel
+}
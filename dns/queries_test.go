@@ -0,0 +1,51 @@
+package dns
+
+import "testing"
+
+func TestQueryLogRingBuffer(t *testing.T) {
+	r := &queryLogRing{subs: map[chan QueryLog]struct{}{}}
+
+	for i := 0; i < queryLogBufferSize+10; i++ {
+		r.push(QueryLog{QName: "example.com."})
+	}
+
+	r.mu.Lock()
+	got := len(r.buf)
+	r.mu.Unlock()
+
+	if got != queryLogBufferSize {
+		t.Fatalf("queryLogRing holds %d entries after overflow, want %d", got, queryLogBufferSize)
+	}
+}
+
+func TestQueryLogRingSubscribe(t *testing.T) {
+	r := &queryLogRing{subs: map[chan QueryLog]struct{}{}}
+
+	ch := make(chan QueryLog, 1)
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+
+	r.push(QueryLog{QName: "sub.example.com."})
+
+	select {
+	case q := <-ch:
+		if q.QName != "sub.example.com." {
+			t.Fatalf("subscriber received %+v, want QName sub.example.com.", q)
+		}
+	default:
+		t.Fatal("subscriber did not receive the pushed QueryLog")
+	}
+}
+
+func TestQueryLogRingDropsForSlowSubscriber(t *testing.T) {
+	r := &queryLogRing{subs: map[chan QueryLog]struct{}{}}
+
+	ch := make(chan QueryLog) // unbuffered and never drained
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+
+	// Must not block even though the subscriber channel can't accept.
+	r.push(QueryLog{QName: "full.example.com."})
+}
@@ -0,0 +1,102 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+
+	D "github.com/miekg/dns"
+
+	"github.com/Dreamacro/clash/common/cache"
+)
+
+// Resolver orchestrates nameserver selection (via ruleMatcher, falling back
+// to main), caching, and batchExchange for every query clash needs to
+// resolve.
+type Resolver struct {
+	main        []dnsClient
+	fallback    []dnsClient
+	ruleMatcher *RuleMatcher
+	lruCache    *cache.LruCache[string, *D.Msg]
+}
+
+// NewResolver builds a Resolver from cfg, applying its negative-cache cap
+// package-wide and building the rule-based nameserver dispatcher.
+func NewResolver(cfg Config) *Resolver {
+	SetMaxNegativeTTL(cfg.MaxNegativeTTL)
+
+	r := &Resolver{
+		lruCache: cache.New[string, *D.Msg](cache.WithSize(4096)),
+	}
+	r.main = transform(cfg.Main, r)
+	r.fallback = transform(cfg.Fallback, r)
+
+	groups := make(map[string][]dnsClient, len(cfg.RuleGroups))
+	for group, servers := range cfg.RuleGroups {
+		groups[group] = transform(servers, r)
+	}
+	r.ruleMatcher = NewRuleMatcher(cfg.Rules, groups, cfg.RuleProxies)
+
+	return r
+}
+
+// Exchange resolves m's question, picking the nameserver group selected by
+// the rule matcher (falling back to the default nameservers) before calling
+// batchExchange, serving from cache when possible.
+//
+// clientAddr is the source address of the client that issued m, as seen by
+// the local DNS listener; it is attached to ctx so ECSAuto nameservers can
+// derive a subnet for it, both for the outgoing ECS option and, only when
+// the resolved group actually has an ECSAuto nameserver, for the cache key.
+func (r *Resolver) Exchange(ctx context.Context, m *D.Msg, clientAddr netip.Addr) (*D.Msg, error) {
+	if len(m.Question) == 0 {
+		return nil, errors.New("dns: no question")
+	}
+	q := m.Question[0]
+
+	var clientIP string
+	if clientAddr.IsValid() {
+		ctx = WithClientAddr(ctx, clientAddr)
+		clientIP = clientAddr.String()
+	}
+
+	clients, rule, ctx, ok := r.ruleMatcher.Resolve(ctx, q.Name)
+	if !ok {
+		clients = r.main
+	}
+	if len(clients) == 0 {
+		clients = r.fallback
+	}
+
+	key := genMsgCacheKey(ctx, q, groupHasECSAuto(clients))
+	if cached, ok := r.lruCache.Get(key); ok {
+		logDnsResponse(q, cached, nil, "", "cache", "", QueryMeta{ClientIP: clientIP, CacheHit: true})
+		return cached, nil
+	}
+
+	start := time.Now()
+	msg, err := batchExchange(ctx, clients, m)
+	logDnsResponse(q, msg, err, "", "", "", QueryMeta{ClientIP: clientIP, Rule: rule, RTT: time.Since(start)})
+	if err != nil {
+		return nil, err
+	}
+
+	putMsgToCache(r.lruCache, key, msg)
+	return msg, nil
+}
+
+// RecentQueries returns the queries held in the in-memory ring buffer,
+// oldest first, for the /dns/queries API endpoint.
+func (r *Resolver) RecentQueries() []QueryLog {
+	return recentQueries()
+}
+
+// SubscribeQueries streams every query/response pair this Resolver
+// handles, for a live DNS log tab the same way dashboards stream HTTP
+// connections. Callers must drain the returned channel promptly; a slow
+// consumer has entries dropped rather than blocking the resolver. Call the
+// returned cancel func to unsubscribe.
+func (r *Resolver) SubscribeQueries() (queries <-chan QueryLog, cancel func()) {
+	return subscribeQueries()
+}
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/geodata/router"
+	C "github.com/Dreamacro/clash/constant"
+)
+
+// Rule binds a domain-matching condition to a nameserver group name,
+// analogous to how the routing rule engine (C.RuleType) binds a condition
+// to an outbound proxy.
+type Rule struct {
+	RuleType C.RuleType
+	Payload  string
+	Group    string
+	matcher  *router.DomainMatcher
+}
+
+// NewDomainRule builds a DOMAIN rule matching the payload exactly.
+func NewDomainRule(payload, group string) Rule {
+	return Rule{RuleType: C.Domain, Payload: payload, Group: group}
+}
+
+// NewDomainSuffixRule builds a DOMAIN-SUFFIX rule.
+func NewDomainSuffixRule(payload, group string) Rule {
+	return Rule{RuleType: C.DomainSuffix, Payload: payload, Group: group}
+}
+
+// NewGeoSiteRule builds a GEOSITE rule backed by matcher, the same
+// router.DomainMatcher the routing rule engine loads for RuleGeoSite.
+func NewGeoSiteRule(payload, group string, matcher *router.DomainMatcher) Rule {
+	return Rule{RuleType: C.GEOSITE, Payload: payload, Group: group, matcher: matcher}
+}
+
+// Match reports whether domain (a query name, with or without the trailing
+// dot) satisfies the rule.
+func (ru Rule) Match(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+
+	switch ru.RuleType {
+	case C.Domain:
+		return strings.EqualFold(domain, ru.Payload)
+	case C.DomainSuffix:
+		return strings.EqualFold(domain, ru.Payload) || strings.HasSuffix(strings.ToLower(domain), "."+strings.ToLower(ru.Payload))
+	case C.GEOSITE:
+		return ru.matcher != nil && ru.matcher.ApplyDomain(domain)
+	default:
+		return false
+	}
+}
+
+// RuleMatcher dispatches a query to the nameserver group selected by the
+// first matching Rule, falling back to the resolver's default nameservers
+// when nothing matches.
+type RuleMatcher struct {
+	rules   []Rule
+	groups  map[string][]dnsClient
+	proxies map[string]string
+}
+
+// NewRuleMatcher builds a RuleMatcher. groups maps a rule's Group to the
+// dnsClients configured for it; proxies optionally maps a Group to the
+// outbound proxy its DoH/DoT clients should dial through.
+func NewRuleMatcher(rules []Rule, groups map[string][]dnsClient, proxies map[string]string) *RuleMatcher {
+	return &RuleMatcher{rules: rules, groups: groups, proxies: proxies}
+}
+
+// Resolve returns the dnsClient group for the first rule matching domain
+// whose group has at least one client (a matching rule with a missing or
+// empty group is skipped), the name of that group (for logging), and a
+// context carrying the group's proxy (if any) via proxyKey so getTCPConn
+// routes DoH/DoT through it. ok is false when no such rule matches, in
+// which case callers should fall back to the default nameservers.
+//
+// NOTE: this only works end-to-end if the matched group's dnsClient reads
+// ctx.Value(proxyKey) on every ExchangeContext call (e.g. by dialing
+// through getTCPConn per-request) rather than only honoring the proxy it
+// was constructed with. dns/doh.go (the DoH transport newDoHClient builds)
+// isn't part of this tree, so that can't be confirmed here — verify it
+// before relying on per-rule-group proxy selection for DoH nameservers.
+func (m *RuleMatcher) Resolve(ctx context.Context, domain string) (clients []dnsClient, group string, out context.Context, ok bool) {
+	if m == nil {
+		return nil, "", ctx, false
+	}
+
+	for _, ru := range m.rules {
+		if !ru.Match(domain) {
+			continue
+		}
+
+		clients, ok = m.groups[ru.Group]
+		if !ok || len(clients) == 0 {
+			continue
+		}
+
+		out = ctx
+		if proxy := m.proxies[ru.Group]; proxy != "" {
+			out = context.WithValue(ctx, proxyKey, proxy)
+		}
+
+		return clients, ru.Group, out, true
+	}
+
+	return nil, "", ctx, false
+}
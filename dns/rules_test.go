@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRuleMatch(t *testing.T) {
+	cases := []struct {
+		name   string
+		rule   Rule
+		domain string
+		want   bool
+	}{
+		{"domain exact match", NewDomainRule("example.com", "g"), "example.com", true},
+		{"domain exact match trailing dot", NewDomainRule("example.com", "g"), "example.com.", true},
+		{"domain is case-insensitive", NewDomainRule("Example.COM", "g"), "example.com", true},
+		{"domain does not match subdomain", NewDomainRule("example.com", "g"), "www.example.com", false},
+		{"domain-suffix matches exact", NewDomainSuffixRule("example.com", "g"), "example.com", true},
+		{"domain-suffix matches subdomain", NewDomainSuffixRule("example.com", "g"), "www.example.com", true},
+		{"domain-suffix does not match unrelated suffix", NewDomainSuffixRule("example.com", "g"), "notexample.com", false},
+	}
+
+	for _, c := range cases {
+		if got := c.rule.Match(c.domain); got != c.want {
+			t.Errorf("%s: Match(%q) = %v, want %v", c.name, c.domain, got, c.want)
+		}
+	}
+}
+
+func TestRuleMatchGeoSiteWithoutMatcher(t *testing.T) {
+	ru := NewGeoSiteRule("cn", "g", nil)
+	if ru.Match("example.com") {
+		t.Fatal("GEOSITE rule with a nil matcher must never match")
+	}
+}
+
+func TestRuleMatcherResolve(t *testing.T) {
+	fallbackGroup := []dnsClient{}
+	adsGroup := []dnsClient{&doqClient{}}
+
+	rules := []Rule{
+		NewDomainSuffixRule("ads.example.com", "ads"),
+		NewDomainRule("empty.example.com", "empty-group"),
+	}
+	groups := map[string][]dnsClient{
+		"ads":         adsGroup,
+		"empty-group": fallbackGroup,
+	}
+	proxies := map[string]string{"ads": "my-proxy"}
+
+	m := NewRuleMatcher(rules, groups, proxies)
+
+	t.Run("matching rule returns its group and wires the proxy into ctx", func(t *testing.T) {
+		clients, group, ctx, ok := m.Resolve(context.Background(), "tracker.ads.example.com")
+		if !ok {
+			t.Fatal("Resolve() did not match a rule it should have")
+		}
+		if group != "ads" {
+			t.Fatalf("Resolve() group = %q, want %q", group, "ads")
+		}
+		if len(clients) != 1 {
+			t.Fatalf("Resolve() returned %d clients, want 1", len(clients))
+		}
+		if proxy, _ := ctx.Value(proxyKey).(string); proxy != "my-proxy" {
+			t.Fatalf("Resolve() proxy in ctx = %q, want %q", proxy, "my-proxy")
+		}
+	})
+
+	t.Run("rule matching an empty group is skipped", func(t *testing.T) {
+		_, _, _, ok := m.Resolve(context.Background(), "empty.example.com")
+		if ok {
+			t.Fatal("Resolve() matched a rule whose group has no clients")
+		}
+	})
+
+	t.Run("no matching rule", func(t *testing.T) {
+		_, _, _, ok := m.Resolve(context.Background(), "unrelated.org")
+		if ok {
+			t.Fatal("Resolve() matched a domain with no applicable rule")
+		}
+	})
+
+	t.Run("nil matcher always misses", func(t *testing.T) {
+		var nilMatcher *RuleMatcher
+		_, _, _, ok := nilMatcher.Resolve(context.Background(), "tracker.ads.example.com")
+		if ok {
+			t.Fatal("nil RuleMatcher.Resolve() reported a match")
+		}
+	})
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	D "github.com/miekg/dns"
@@ -24,15 +26,39 @@ import (
 const (
 	proxyKey     = contextKey("key-dns-client-proxy")
 	proxyTimeout = 10 * time.Second
+
+	// defaultMaxNegativeTTL bounds how long a negative (NXDOMAIN/NODATA)
+	// answer is cached when the DNS config's max-negative-ttl is unset.
+	defaultMaxNegativeTTL = 3600
 )
 
+// maxNegativeTTL is the configured cap (in seconds) for negative caching,
+// set from the DNS config's max-negative-ttl field at resolver construction
+// time via SetMaxNegativeTTL.
+var maxNegativeTTL atomic.Uint32
+
+// SetMaxNegativeTTL configures the cap applied to negative (NXDOMAIN/NODATA)
+// cache entries. A ttl of 0 restores defaultMaxNegativeTTL.
+func SetMaxNegativeTTL(ttl uint32) {
+	maxNegativeTTL.Store(ttl)
+}
+
 func putMsgToCache(c *cache.LruCache[string, *D.Msg], key string, msg *D.Msg) {
 	putMsgToCacheWithExpire(c, key, msg, 0)
 }
 
 func putMsgToCacheWithExpire(c *cache.LruCache[string, *D.Msg], key string, msg *D.Msg, ttl uint32) {
+	if len(msg.Question) > 0 && isACMEChallengeQuery(msg.Question[0]) {
+		// _acme-challenge TXT records are re-queried by ACME DNS-01
+		// validators in quick succession; caching them risks serving a
+		// stale challenge and failing certificate issuance.
+		return
+	}
+
 	if ttl == 0 {
-		if ttl = minTTL(msg.Answer); ttl == 0 {
+		if neg, ok := negativeTTL(msg); ok {
+			ttl = neg
+		} else if ttl = minTTL(msg.Answer); ttl == 0 {
 			return
 		}
 	}
@@ -40,6 +66,42 @@ func putMsgToCacheWithExpire(c *cache.LruCache[string, *D.Msg], key string, msg
 	c.SetWithExpire(key, msg.Copy(), time.Now().Add(time.Duration(ttl)*time.Second))
 }
 
+func isACMEChallengeQuery(q D.Question) bool {
+	if q.Qtype != D.TypeTXT {
+		return false
+	}
+	// DNS names aren't guaranteed to come over the wire lowercased (case
+	// randomization, resolver-dependent casing), so compare case-insensitively.
+	name := q.Name
+	const label = "_acme-challenge."
+	return len(name) >= len(label) && strings.EqualFold(name[:len(label)], label)
+}
+
+// negativeTTL implements RFC 2308 negative caching: an NXDOMAIN or NODATA
+// response is cached for the MINIMUM field of the SOA record carried in the
+// authority section, capped by the configured max-negative-ttl.
+func negativeTTL(msg *D.Msg) (uint32, bool) {
+	if msg.Rcode != D.RcodeNameError && !(msg.Rcode == D.RcodeSuccess && len(msg.Answer) == 0) {
+		return 0, false
+	}
+
+	for _, rr := range msg.Ns {
+		if soa, ok := rr.(*D.SOA); ok {
+			ttl := soa.Minttl
+			ttlCap := maxNegativeTTL.Load()
+			if ttlCap == 0 {
+				ttlCap = defaultMaxNegativeTTL
+			}
+			if ttl > ttlCap {
+				ttl = ttlCap
+			}
+			return ttl, true
+		}
+	}
+
+	return 0, false
+}
+
 func setMsgTTL(msg *D.Msg, ttl uint32) {
 	setMsgTTLWithForce(msg, ttl, true)
 }
@@ -85,16 +147,25 @@ func isIPRequest(q D.Question) bool {
 func transform(servers []NameServer, r *Resolver) []dnsClient {
 	var ret []dnsClient
 	for _, s := range servers {
+		policy, err := ParseECSPolicy(s.ECSPolicy)
+		if err != nil {
+			log.Warn().Err(err).Str("nameserver", s.Addr).Msg("[DNS] invalid ecs-policy, disabling ECS for this nameserver")
+			policy = ECSPolicy{Mode: ECSDisabled}
+		}
+
 		switch s.Net {
 		case "https":
-			ret = append(ret, newDoHClient(s.Addr, s.Proxy, r))
+			ret = append(ret, newDoHClient(s.Addr, s.Proxy, r, policy))
 			continue
 		case "dhcp":
-			ret = append(ret, newDHCPClient(s.Addr))
+			ret = append(ret, newDHCPClient(s.Addr, policy))
+			continue
+		case "quic":
+			ret = append(ret, newDoQClient(s.Addr, s.Proxy, s.Interface, r, policy))
 			continue
 		}
 
-		ret = append(ret, newClient(s.Net, s.Addr, s.Proxy, s.Interface, s.IsDHCP, r))
+		ret = append(ret, newClient(s.Net, s.Addr, s.Proxy, s.Interface, s.IsDHCP, r, policy))
 	}
 	return ret
 }
@@ -231,21 +302,40 @@ tcp:
 	return proxy.DialContext(ctx, metadata, opts...)
 }
 
+// batchExchange issues m to the fastest known client first and only hedges
+// out to the remaining clients, one at a time, if no usable reply arrives
+// within that client's recent p95 RTT. This trades a small amount of
+// worst-case latency for a large cut in upstream DNS traffic, since most
+// queries never need to fan out at all. The first non-SERVFAIL/REFUSED
+// reply cancels every other in-flight client via ctx.
 func batchExchange(ctx context.Context, clients []dnsClient, m *D.Msg) (msg *D.Msg, err error) {
+	if len(clients) == 0 {
+		return nil, errors.New("no dns client available")
+	}
+
+	ordered := orderClientsByLatency(clients)
+
 	fast, ctx := picker.WithContext[*D.Msg](ctx)
-	for _, clientM := range clients {
-		r := clientM
+	launch := func(r dnsClient) {
 		fast.Go(func() (*D.Msg, error) {
-			mm, fErr := r.ExchangeContext(ctx, m)
+			start := time.Now()
+			mm, fErr := r.ExchangeContext(ctx, withECS(ctx, m, r.ECSPolicy()))
+			ok := fErr == nil && mm.Rcode != D.RcodeServerFailure && mm.Rcode != D.RcodeRefused
+			globalClientStats.record(r.Address(), time.Since(start), ok)
 			if fErr != nil {
 				return nil, fErr
-			} else if mm.Rcode == D.RcodeServerFailure || mm.Rcode == D.RcodeRefused {
+			} else if !ok {
 				return nil, errors.New("server failure")
 			}
 			return mm, nil
 		})
 	}
 
+	launch(ordered[0])
+	if len(ordered) > 1 {
+		go hedgeRemaining(ctx, ordered, launch)
+	}
+
 	elm := fast.Wait()
 	if elm == nil {
 		err = errors.New("all DNS requests failed")
@@ -258,10 +348,38 @@ func batchExchange(ctx context.Context, clients []dnsClient, m *D.Msg) (msg *D.M
 	return elm, nil
 }
 
-func genMsgCacheKey(ctx context.Context, q D.Question) string {
+// hedgeRemaining fans out to ordered[1:] one at a time, waiting the hedge
+// delay of whichever client is currently in flight (the one we're waiting
+// on, not the one about to launch) before moving to the next, and stops as
+// soon as ctx is cancelled (a usable reply has already won).
+func hedgeRemaining(ctx context.Context, ordered []dnsClient, launch func(dnsClient)) {
+	inFlight := ordered[0]
+	for _, next := range ordered[1:] {
+		timer := time.NewTimer(globalClientStats.hedgeDelay(inFlight.Address()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			launch(next)
+			inFlight = next
+		}
+	}
+}
+
+func genMsgCacheKey(ctx context.Context, q D.Question, hasECSAuto bool) string {
+	ecs := ecsCacheKey(ctx, hasECSAuto)
+
 	if proxy, ok := resolver.GetProxy(ctx); ok && proxy != "" {
+		if ecs != "" {
+			return fmt.Sprintf("%s:%s:%s:%d:%d", proxy, ecs, q.Name, q.Qtype, q.Qclass)
+		}
 		return fmt.Sprintf("%s:%s:%d:%d", proxy, q.Name, q.Qtype, q.Qclass)
 	}
+
+	if ecs != "" {
+		return fmt.Sprintf("%s:%s:%d:%d", ecs, q.Name, q.Qtype, q.Qclass)
+	}
 	return fmt.Sprintf("%s:%d:%d", q.Name, q.Qtype, q.Qclass)
 }
 
@@ -285,7 +403,37 @@ func getTCPConn(ctx context.Context, addr string) (conn net.Conn, err error) {
 	return
 }
 
-func logDnsResponse(q D.Question, msg *D.Msg, err error, network, source, proxyAdapter string) {
+// QueryMeta carries the sniff-based metadata logDnsResponse attaches to a
+// query's structured log entry and its QueryLog record: the originating
+// client, the process that issued it (see QueryLog.Process; left empty by
+// every caller in this tree today), and the DNS rule (if any) that picked
+// the upstream.
+type QueryMeta struct {
+	ClientIP string
+	Process  string
+	Rule     string
+	RTT      time.Duration
+	CacheHit bool
+}
+
+func logDnsResponse(q D.Question, msg *D.Msg, err error, network, source, proxyAdapter string, meta QueryMeta) {
+	entry := QueryLog{
+		Time:     time.Now(),
+		ClientIP: meta.ClientIP,
+		Process:  meta.Process,
+		Rule:     meta.Rule,
+		QName:    q.Name,
+		QType:    D.Type(q.Qtype).String(),
+		Upstream: network + source,
+		Proxy:    proxyAdapter,
+		RTT:      meta.RTT,
+		CacheHit: meta.CacheHit,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	globalQueryLog.push(entry)
+
 	if q.Qtype != D.TypeA && q.Qtype != D.TypeAAAA {
 		return
 	}
@@ -304,12 +452,18 @@ func logDnsResponse(q D.Question, msg *D.Msg, err error, network, source, proxyA
 			Str("source", fmt.Sprintf("%s%s%s", network, source, pr)).
 			Str("qType", D.Type(q.Qtype).String()).
 			Str("name", q.Name).
+			Str("clientIP", meta.ClientIP).
+			Str("process", meta.Process).
+			Str("rule", meta.Rule).
 			Msg("[DNS] dns response failed")
 	} else if msg != nil {
 		log.Debug().
 			Str("source", fmt.Sprintf("%s%s%s", network, source, pr)).
 			Str("qType", D.Type(q.Qtype).String()).
 			Str("name", q.Name).
+			Str("clientIP", meta.ClientIP).
+			Str("process", meta.Process).
+			Str("rule", meta.Rule).
 			Strs("answer", msgToIPStr(msg)).
 			Msg("[DNS] dns response")
 	}
@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"testing"
+
+	D "github.com/miekg/dns"
+
+	"github.com/Dreamacro/clash/common/cache"
+)
+
+func TestIsACMEChallengeQuery(t *testing.T) {
+	cases := []struct {
+		name  string
+		qtype uint16
+		want  bool
+	}{
+		{"_acme-challenge.example.com.", D.TypeTXT, true},
+		{"_ACME-Challenge.example.com.", D.TypeTXT, true},
+		{"_AcMe-ChAlLeNgE.example.com.", D.TypeTXT, true},
+		{"example.com.", D.TypeTXT, false},
+		{"_acme-challenge.example.com.", D.TypeA, false},
+		{"not-acme-challenge.example.com.", D.TypeTXT, false},
+	}
+
+	for _, c := range cases {
+		q := D.Question{Name: c.name, Qtype: c.qtype, Qclass: D.ClassINET}
+		if got := isACMEChallengeQuery(q); got != c.want {
+			t.Errorf("isACMEChallengeQuery(%q, %v) = %v, want %v", c.name, c.qtype, got, c.want)
+		}
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	soa := &D.SOA{Hdr: D.RR_Header{Rrtype: D.TypeSOA}, Minttl: 600}
+
+	t.Run("nxdomain uses SOA minimum", func(t *testing.T) {
+		msg := &D.Msg{}
+		msg.SetRcode(&D.Msg{}, D.RcodeNameError)
+		msg.Ns = []D.RR{soa}
+
+		ttl, ok := negativeTTL(msg)
+		if !ok || ttl != 600 {
+			t.Fatalf("negativeTTL() = (%d, %v), want (600, true)", ttl, ok)
+		}
+	})
+
+	t.Run("nodata uses SOA minimum", func(t *testing.T) {
+		msg := &D.Msg{}
+		msg.SetRcode(&D.Msg{}, D.RcodeSuccess)
+		msg.Ns = []D.RR{soa}
+
+		ttl, ok := negativeTTL(msg)
+		if !ok || ttl != 600 {
+			t.Fatalf("negativeTTL() = (%d, %v), want (600, true)", ttl, ok)
+		}
+	})
+
+	t.Run("capped by max-negative-ttl", func(t *testing.T) {
+		maxNegativeTTL.Store(60)
+		defer maxNegativeTTL.Store(0)
+
+		msg := &D.Msg{}
+		msg.SetRcode(&D.Msg{}, D.RcodeNameError)
+		msg.Ns = []D.RR{soa}
+
+		ttl, ok := negativeTTL(msg)
+		if !ok || ttl != 60 {
+			t.Fatalf("negativeTTL() = (%d, %v), want (60, true)", ttl, ok)
+		}
+	})
+
+	t.Run("successful answer is not negative", func(t *testing.T) {
+		msg := &D.Msg{}
+		msg.SetRcode(&D.Msg{}, D.RcodeSuccess)
+		msg.Answer = []D.RR{&D.A{Hdr: D.RR_Header{Rrtype: D.TypeA, Ttl: 300}}}
+		msg.Ns = []D.RR{soa}
+
+		if _, ok := negativeTTL(msg); ok {
+			t.Fatal("negativeTTL() reported a negative answer for a response with Answer records")
+		}
+	})
+
+	t.Run("no SOA in authority section", func(t *testing.T) {
+		msg := &D.Msg{}
+		msg.SetRcode(&D.Msg{}, D.RcodeNameError)
+
+		if _, ok := negativeTTL(msg); ok {
+			t.Fatal("negativeTTL() succeeded without an SOA record")
+		}
+	})
+}
+
+func TestPutMsgToCacheWithExpire(t *testing.T) {
+	newCache := func() *cache.LruCache[string, *D.Msg] {
+		return cache.New[string, *D.Msg](cache.WithSize(16))
+	}
+
+	t.Run("skips _acme-challenge TXT queries", func(t *testing.T) {
+		c := newCache()
+		msg := &D.Msg{}
+		msg.Question = []D.Question{{Name: "_acme-challenge.example.com.", Qtype: D.TypeTXT, Qclass: D.ClassINET}}
+		msg.Answer = []D.RR{&D.TXT{Hdr: D.RR_Header{Rrtype: D.TypeTXT, Ttl: 300}, Txt: []string{"token"}}}
+
+		putMsgToCache(c, "key", msg)
+
+		if _, ok := c.Get("key"); ok {
+			t.Fatal("putMsgToCache cached an _acme-challenge TXT response")
+		}
+	})
+
+	t.Run("falls back to answer min TTL", func(t *testing.T) {
+		c := newCache()
+		msg := &D.Msg{}
+		msg.Question = []D.Question{{Name: "example.com.", Qtype: D.TypeA, Qclass: D.ClassINET}}
+		msg.Answer = []D.RR{&D.A{Hdr: D.RR_Header{Rrtype: D.TypeA, Ttl: 300}}}
+
+		putMsgToCache(c, "key", msg)
+
+		if _, ok := c.Get("key"); !ok {
+			t.Fatal("putMsgToCache did not cache a response with a positive answer TTL")
+		}
+	})
+
+	t.Run("zero TTL and no answer is not cached", func(t *testing.T) {
+		c := newCache()
+		msg := &D.Msg{}
+		msg.Question = []D.Question{{Name: "example.com.", Qtype: D.TypeA, Qclass: D.ClassINET}}
+
+		putMsgToCache(c, "key", msg)
+
+		if _, ok := c.Get("key"); ok {
+			t.Fatal("putMsgToCache cached a response with no TTL to derive")
+		}
+	})
+
+	t.Run("negative answer uses SOA minimum", func(t *testing.T) {
+		c := newCache()
+		msg := &D.Msg{}
+		msg.Question = []D.Question{{Name: "example.com.", Qtype: D.TypeA, Qclass: D.ClassINET}}
+		msg.SetRcode(msg, D.RcodeNameError)
+		msg.Ns = []D.RR{&D.SOA{Hdr: D.RR_Header{Rrtype: D.TypeSOA}, Minttl: 120}}
+
+		putMsgToCache(c, "key", msg)
+
+		if _, ok := c.Get("key"); !ok {
+			t.Fatal("putMsgToCache did not cache a negative answer")
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package route
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/gorilla/websocket"
+
+	"github.com/Dreamacro/clash/dns"
+)
+
+// dnsQueriesUpgrader upgrades GET /dns/queries to a websocket stream; kept
+// local to this file since the rest of the hub/route package isn't in this
+// tree to share one from.
+var dnsQueriesUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     func(r *http.Request) bool { return true },
+}
+
+// dnsRouter exposes the live DNS query log: a GET returns the recent
+// queries held in Resolver's ring buffer, and a websocket upgrade streams
+// every query/response pair as it happens, mirroring how /connections
+// renders a live HTTP connections tab.
+func dnsRouter(resolver *dns.Resolver) http.Handler {
+	r := chi.NewRouter()
+	r.Get("/queries", getDNSQueries(resolver))
+	r.Get("/clients", getDNSClientStats)
+	return r
+}
+
+// getDNSClientStats returns each tracked nameserver's recent RTT and
+// success rate, for an observability panel alongside the live query log.
+func getDNSClientStats(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, dns.DNSClientStats())
+}
+
+func getDNSQueries(resolver *dns.Resolver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if resolver == nil {
+			render.Status(r, http.StatusServiceUnavailable)
+			render.JSON(w, r, render.M{"message": "dns resolver not ready"})
+			return
+		}
+
+		if !websocket.IsWebSocketUpgrade(r) {
+			render.JSON(w, r, resolver.RecentQueries())
+			return
+		}
+
+		conn, err := dnsQueriesUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		queries, cancel := resolver.SubscribeQueries()
+		defer cancel()
+
+		for q := range queries {
+			if err := conn.WriteJSON(q); err != nil {
+				return
+			}
+		}
+	}
+}